@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// rendezvousScore computes a stable score for the (vopr_id, commit) pair.
+// Under rendezvous (highest random weight) hashing, a VOPR is assigned
+// whichever candidate scores highest for it; since the score only depends
+// on the pair itself, adding or removing one candidate only changes the
+// winner for VOPRs whose ordering that candidate happened to affect, not
+// every VOPR.
+func rendezvousScore(vopr_id int, commit string) uint64 {
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%d|%s", vopr_id, commit)
+	return hash.Sum64()
+}
+
+// assignVoprsRendezvous assigns each VOPR in [1, num_voprs] one commit from
+// {main_commit} ∪ vopr_commits using rendezvous hashing, so that adding or
+// removing a single `vopr`-labelled PR only moves the handful of VOPRs whose
+// score ordering actually changes.
+//
+// main_commit is guaranteed at least ceil(num_voprs / len(candidates)) slots
+// via a weighted reservation: if main's natural rendezvous wins fall short
+// of that share, the VOPRs for which main's own score is highest are
+// reassigned to main.
+func assignVoprsRendezvous(num_voprs int, main_commit string, vopr_commits []string) []string {
+	candidates := make([]string, 0, 1+len(vopr_commits))
+	candidates = append(candidates, main_commit)
+	candidates = append(candidates, vopr_commits...)
+
+	min_main_slots := (num_voprs + len(candidates) - 1) / len(candidates)
+
+	assignments := make([]string, num_voprs)
+	main_slots := 0
+
+	for vopr_id := 1; vopr_id <= num_voprs; vopr_id++ {
+		best_commit := candidates[0]
+		best_score := rendezvousScore(vopr_id, best_commit)
+
+		for _, commit := range candidates[1:] {
+			if score := rendezvousScore(vopr_id, commit); score > best_score {
+				best_commit, best_score = commit, score
+			}
+		}
+
+		assignments[vopr_id-1] = best_commit
+		if best_commit == main_commit {
+			main_slots++
+		}
+	}
+
+	if main_slots >= min_main_slots || len(candidates) == 1 {
+		return assignments
+	}
+
+	// Main fell short of its reserved share. Reassign it the VOPRs with the
+	// highest main-commit scores; that ordering depends only on main and the
+	// VOPR ids, not on which other candidates are present.
+	type contender struct {
+		vopr_index int
+		main_score uint64
+	}
+	contenders := make([]contender, 0, num_voprs-main_slots)
+	for i := 0; i < num_voprs; i++ {
+		if assignments[i] != main_commit {
+			contenders = append(contenders, contender{vopr_index: i, main_score: rendezvousScore(i+1, main_commit)})
+		}
+	}
+	sort.Slice(contenders, func(a, b int) bool { return contenders[a].main_score > contenders[b].main_score })
+
+	for _, c := range contenders {
+		if main_slots >= min_main_slots {
+			break
+		}
+		assignments[c.vopr_index] = main_commit
+		main_slots++
+	}
+
+	return assignments
+}
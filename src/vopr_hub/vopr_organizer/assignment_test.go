@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+// TestAssignVoprsRendezvousIsStable asserts the key property rendezvous
+// hashing is meant to give us: adding one PR to the candidate set should
+// only reshuffle roughly num_voprs/len(candidates) VOPRs, not all of them.
+func TestAssignVoprsRendezvousIsStable(t *testing.T) {
+	const num_voprs = 30
+	main_commit := "main-sha"
+	before_commits := []string{"pr-1-sha", "pr-2-sha", "pr-3-sha"}
+
+	before := assignVoprsRendezvous(num_voprs, main_commit, before_commits)
+
+	after_commits := append(append([]string{}, before_commits...), "pr-4-sha")
+	after := assignVoprsRendezvous(num_voprs, main_commit, after_commits)
+
+	changed := 0
+	for i := range before {
+		if before[i] != after[i] {
+			changed++
+		}
+	}
+
+	// A naive integer-division scheme (the one this replaces) would shift
+	// nearly every slot; rendezvous hashing should only move roughly
+	// num_voprs / len(candidates after the change) of them. Leave some
+	// slack for the main-commit reservation rebalancing.
+	max_expected_changes := num_voprs/(1+len(after_commits)) + 3
+	if changed > max_expected_changes {
+		t.Fatalf(
+			"expected at most %d changed assignments out of %d after adding one PR, got %d",
+			max_expected_changes, num_voprs, changed,
+		)
+	}
+}
+
+// TestAssignVoprsRendezvousReservesMainShare asserts main always gets at
+// least its weighted-reservation share of VOPRs when it competes with a
+// crowded PR candidate set (four PRs for ten VOPRs).
+func TestAssignVoprsRendezvousReservesMainShare(t *testing.T) {
+	const num_voprs = 10
+	main_commit := "main-sha"
+	vopr_commits := []string{"pr-1-sha", "pr-2-sha", "pr-3-sha", "pr-4-sha"}
+
+	assignments := assignVoprsRendezvous(num_voprs, main_commit, vopr_commits)
+
+	main_slots := 0
+	for _, commit := range assignments {
+		if commit == main_commit {
+			main_slots++
+		}
+	}
+
+	min_expected := (num_voprs + 1 + len(vopr_commits) - 1) / (1 + len(vopr_commits))
+	if main_slots < min_expected {
+		t.Fatalf("expected main to receive at least %d of %d slots, got %d", min_expected, num_voprs, main_slots)
+	}
+}
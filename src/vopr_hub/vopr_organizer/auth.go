@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// githubOAuthClientID returns the GitHub OAuth App client ID to use for the
+// device-flow login. OAuth App client IDs are not secret, but there is no
+// single ID that works for every self-hosted operator's fork/mirror, so
+// operators must register their own GitHub OAuth App (Settings > Developer
+// settings > OAuth Apps, device flow enabled) and set GITHUB_OAUTH_CLIENT_ID
+// to its client ID before running `vopr-hub login`.
+func githubOAuthClientID() (string, error) {
+	client_id := os.Getenv("GITHUB_OAUTH_CLIENT_ID")
+	if client_id == "" {
+		return "", fmt.Errorf("GITHUB_OAUTH_CLIENT_ID must be set to a GitHub OAuth App client ID to use `vopr-hub login`")
+	}
+	return client_id, nil
+}
+
+type loginConfig struct {
+	GithubToken string `json:"github_token"`
+}
+
+// configFilePath returns where the persisted login config lives, following
+// the XDG base directory spec like the rest of the TigerBeetle tooling.
+func configFilePath() (string, error) {
+	config_home := os.Getenv("XDG_CONFIG_HOME")
+	if config_home == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("unable to determine home directory: %w", err)
+		}
+		config_home = filepath.Join(home, ".config")
+	}
+	return filepath.Join(config_home, "tigerbeetle", "vopr-hub.json"), nil
+}
+
+// loadPersistedGithubToken returns the token saved by `vopr-hub login`, or
+// "" if none has been saved (or it can't be read).
+func loadPersistedGithubToken() string {
+	path, err := configFilePath()
+	if err != nil {
+		return ""
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	var config loginConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return ""
+	}
+	return config.GithubToken
+}
+
+func savePersistedGithubToken(token string) (string, error) {
+	path, err := configFilePath()
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", fmt.Errorf("unable to create config directory: %w", err)
+	}
+
+	data, err := json.Marshal(loginConfig{GithubToken: token})
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", fmt.Errorf("unable to write %s: %w", path, err)
+	}
+	return path, nil
+}
+
+type githubDeviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+type githubAccessToken struct {
+	AccessToken string `json:"access_token"`
+	Error       string `json:"error"`
+}
+
+// runLogin drives the GitHub OAuth device-authorization flow (RFC 8628) so
+// self-hosted operators can authenticate the hub without pasting a PAT, and
+// persists the resulting token to disk for future runs.
+func runLogin() error {
+	client_id, err := githubOAuthClientID()
+	if err != nil {
+		return err
+	}
+
+	device, err := githubRequestDeviceCode(client_id)
+	if err != nil {
+		return fmt.Errorf("unable to start device authorization: %w", err)
+	}
+
+	fmt.Printf(
+		"To authenticate the VOPR Hub with GitHub, visit:\n\n    %s\n\nand enter the code: %s\n\n",
+		device.VerificationURI,
+		device.UserCode,
+	)
+
+	interval := time.Duration(device.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(device.ExpiresIn) * time.Second)
+
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+
+		token, err := githubPollAccessToken(client_id, device.DeviceCode)
+		if err != nil {
+			return fmt.Errorf("unable to poll for access token: %w", err)
+		}
+
+		switch token.Error {
+		case "authorization_pending":
+			continue
+		case "slow_down":
+			interval += 5 * time.Second
+			continue
+		case "":
+			path, err := savePersistedGithubToken(token.AccessToken)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Authenticated. Token saved to %s\n", path)
+			return nil
+		default:
+			return fmt.Errorf("github device authorization failed: %s", token.Error)
+		}
+	}
+
+	return fmt.Errorf("device authorization expired before the code was entered")
+}
+
+func githubRequestDeviceCode(client_id string) (*githubDeviceCode, error) {
+	var device githubDeviceCode
+	form := url.Values{"client_id": {client_id}}
+	if err := githubOAuthPost("https://github.com/login/device/code", form, &device); err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+func githubPollAccessToken(client_id, device_code string) (*githubAccessToken, error) {
+	var token githubAccessToken
+	form := url.Values{
+		"client_id":   {client_id},
+		"device_code": {device_code},
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+	}
+	if err := githubOAuthPost("https://github.com/login/oauth/access_token", form, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// githubOAuthPost POSTs form to GitHub's OAuth endpoints and decodes the
+// JSON response. The Accept header is required; without it these endpoints
+// default to form-encoded responses per RFC 8628's GitHub implementation.
+func githubOAuthPost(endpoint string, form url.Values, out any) error {
+	request, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+
+	res, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	return json.NewDecoder(res.Body).Decode(out)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Default timeouts for the two kinds of git operations the hub runs. A
+// wedged `git fetch` against a slow remote would otherwise hang forever.
+const (
+	git_fetch_timeout    = 5 * time.Minute
+	git_checkout_timeout = 30 * time.Second
+)
+
+// RunContext describes a single git invocation: its working directory,
+// deadline, and where to send/capture output. Modeled on Gitea's internal
+// RunContext{Timeout, Dir, Env, Stdin, Stdout, Stderr}.
+type RunContext struct {
+	Context context.Context
+	Dir     string
+	Env     []string
+	Timeout time.Duration
+	Stdin   io.Reader
+	Stdout  io.Writer
+	Stderr  io.Writer
+}
+
+// RunError is returned when a git command exits non-zero or is killed by
+// its timeout/cancellation. It carries the command line, exit code, and a
+// stderr tail so callers can log a useful message without re-parsing
+// exec.ExitError themselves.
+type RunError struct {
+	Args     []string
+	Dir      string
+	ExitCode int
+	Stderr   string
+	Err      error
+}
+
+const stderr_tail_limit = 4096
+
+func (e *RunError) Error() string {
+	stderr_tail := e.Stderr
+	if len(stderr_tail) > stderr_tail_limit {
+		stderr_tail = "..." + stderr_tail[len(stderr_tail)-stderr_tail_limit:]
+	}
+	return fmt.Sprintf(
+		"git %s (dir %s) failed with exit code %d: %s\n%s",
+		strings.Join(e.Args, " "),
+		e.Dir,
+		e.ExitCode,
+		e.Err,
+		stderr_tail,
+	)
+}
+
+func (e *RunError) Unwrap() error {
+	return e.Err
+}
+
+// runGit runs `git <args...>` per rc, enforcing rc.Timeout against rc.Context
+// (or context.Background() if rc.Context is nil), and returns stdout on
+// success or a *RunError describing the failure.
+func runGit(rc RunContext, args ...string) ([]byte, error) {
+	ctx := rc.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if rc.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rc.Timeout)
+		defer cancel()
+	}
+
+	command := exec.CommandContext(ctx, "git", args...)
+	command.Dir = rc.Dir
+	if len(rc.Env) > 0 {
+		command.Env = rc.Env
+	}
+	if rc.Stdin != nil {
+		command.Stdin = rc.Stdin
+	}
+
+	var stdout, stderr bytes.Buffer
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	if rc.Stdout != nil {
+		command.Stdout = io.MultiWriter(&stdout, rc.Stdout)
+	}
+	if rc.Stderr != nil {
+		command.Stderr = io.MultiWriter(&stderr, rc.Stderr)
+	}
+
+	err := command.Run()
+	if err != nil {
+		exit_code := -1
+		if exit_error, ok := err.(*exec.ExitError); ok {
+			exit_code = exit_error.ExitCode()
+		}
+		return stdout.Bytes(), &RunError{
+			Args:     args,
+			Dir:      rc.Dir,
+			ExitCode: exit_code,
+			Stderr:   stderr.String(),
+			Err:      err,
+		}
+	}
+
+	return stdout.Bytes(), nil
+}
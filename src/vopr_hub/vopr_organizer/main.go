@@ -1,43 +1,30 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 )
 
 var (
-	debug_mode            bool
-	tigerbeetle_directory string
-	repository_url        string
-	num_voprs             int
-	current_vopr          int
+	debug_mode                bool
+	tigerbeetle_directory     string
+	bare_repository_directory string
+	repository_url            string
+	forge_type                string
+	github_token              string
+	num_voprs                 int
+	current_vopr              int
+	source                    SourceProvider
+	worktrees                 *worktreeManager
 )
 
-type Label struct {
-	Name string `json:"name"`
-}
-
-type Head struct {
-	Label string `json:"label"`
-}
-
-type Issue struct {
-	Labels []Label `json:"labels"`
-	Head   Head    `json:"head"`
-}
-
-type Commit struct {
-	Sha string `json:"sha"`
-}
-
 func set_environment_variables() {
 	var found bool
 	tigerbeetle_directory, found = os.LookupEnv("TIGERBEETLE_DIRECTORY")
@@ -53,6 +40,18 @@ func set_environment_variables() {
 		os.Exit(1)
 	}
 
+	bare_repository_directory, found = os.LookupEnv("BARE_REPOSITORY_DIRECTORY")
+	if !found {
+		log_error("Could not find BARE_REPOSITORY_DIRECTORY environmental variable")
+		os.Exit(1)
+	} else if bare_repository_directory != "" {
+		bare_repository_directory = strings.TrimRight(bare_repository_directory, "/\\")
+		log_debug("bare_repository_directory set as " + bare_repository_directory)
+	} else {
+		log_error("BARE_REPOSITORY_DIRECTORY was empty")
+		os.Exit(1)
+	}
+
 	repository_url, found = os.LookupEnv("REPOSITORY_URL")
 	if !found {
 		log_error("Could not find REPOSITORY_URL environmental variable")
@@ -64,6 +63,18 @@ func set_environment_variables() {
 		os.Exit(1)
 	}
 
+	// FORGE_TYPE is optional and defaults to "github", so existing
+	// deployments pointed at the GitHub API keep working unchanged.
+	forge_type = os.Getenv("FORGE_TYPE")
+	log_debug("forge_type set as " + forge_type)
+
+	// GITHUB_TOKEN is optional; falls back to a token saved by
+	// `vopr-hub login`, then to unauthenticated requests.
+	github_token = os.Getenv("GITHUB_TOKEN")
+	if github_token == "" {
+		github_token = loadPersistedGithubToken()
+	}
+
 	num_voprs_str, found := os.LookupEnv("NUM_VOPRS")
 	if !found {
 		log_error("Could not find NUM_VOPRS environmental variable")
@@ -107,206 +118,106 @@ func set_environment_variables() {
 	}
 }
 
-// Fetch available branches from GitHub and checkout the correct branch if it exists.
-func checkout_branch(branch string, tigerbeetle_directory string) error {
-	// Git commands need to be run with the particular TigerBeetle directory as their
-	// working_directory
-	fetch_command := exec.Command("git", "fetch", "--all")
-	fetch_command.Dir = tigerbeetle_directory
-	error := fetch_command.Run()
-	if error != nil {
-		error_message := fmt.Sprintf("Failed to run git fetch: %s", error.Error())
-		log_error(error_message)
-		return error
-	}
-
-	// Checkout the branch
-	checkout_command := exec.Command("git", "checkout", branch)
-	checkout_command.Dir = tigerbeetle_directory
-	error = checkout_command.Run()
-	if error != nil {
-		error_message := fmt.Sprintf("Failed to run git checkout: %s", error.Error())
-		log_error(error_message)
-		return error
-	}
-
-	// Inspect the git logs.
-	log_command := exec.Command("git", "branch", "--show-current")
-	log_command.Dir = tigerbeetle_directory
-	log_output, error := log_command.Output()
-	if error != nil {
-		error_message := fmt.Sprintf("Failed to run git log: %s", error.Error())
-		log_error(error_message)
-		return error
+// serve_environment_variables reads the -serve-only environment variables,
+// applying defaults for any that are unset.
+func serve_environment_variables() (poll_interval time.Duration, database_path string, listen_address string) {
+	poll_interval = 5 * time.Minute
+	if poll_interval_str := os.Getenv("POLL_INTERVAL"); poll_interval_str != "" {
+		parsed, err := time.ParseDuration(poll_interval_str)
+		if err != nil {
+			log_error("unable to parse POLL_INTERVAL as a duration: " + err.Error())
+			os.Exit(1)
+		}
+		poll_interval = parsed
 	}
 
-	// Check the log to determine if the branch has been successfully checked out.
-	current_branch := string(log_output[:])
-	if !(current_branch == branch) {
-		error = fmt.Errorf("Checkout failed")
-		return error
+	database_path = os.Getenv("SQLITE_PATH")
+	if database_path == "" {
+		database_path = "vopr-hub.sqlite"
 	}
 
-	return nil
-}
-
-func get_pull_requests(num_posts int, page_number int) []Issue {
-	pull_requests := []Issue{}
-	res, err := http.Get(fmt.Sprintf("%s/pulls?per_page=%d&page=%d", repository_url, num_posts, page_number))
-	if err != nil {
-		log_error("unable to create get request")
-		panic(err.Error())
-	}
-	body, err := io.ReadAll(res.Body)
-	res.Body.Close()
-	if res.StatusCode > 299 {
-		log_error(
-			fmt.Sprintf(
-				"Response failed with status code: %d and\nbody: %s\n",
-				res.StatusCode,
-				body,
-			),
-		)
-		panic(err.Error())
-	}
-	if err != nil {
-		log_error("unable to receive a response from GitHub")
-		panic(err.Error())
+	listen_address = os.Getenv("HTTP_ADDRESS")
+	if listen_address == "" {
+		listen_address = ":8080"
 	}
 
-	err = json.Unmarshal(body, &pull_requests)
-	if err != nil {
-		log_error("unable to unmarshall json")
-		panic(err.Error())
-	}
-	fmt.Printf("Num PRs: %d\n", len(pull_requests))
-	return pull_requests
+	return poll_interval, database_path, listen_address
 }
 
-func get_commits(branch_name string) string {
-	commits := []Commit{}
-	res, err := http.Get(fmt.Sprintf("%s/commits?per_page=1&sha=%s", repository_url, branch_name))
-	if err != nil {
-		log_error("unable to create get request")
-		panic(err.Error())
-	}
-	body, err := io.ReadAll(res.Body)
-	res.Body.Close()
-	if res.StatusCode > 299 {
-		log_error(
-			fmt.Sprintf(
-				"Response failed with status code: %d and\nbody: %s\n",
-				res.StatusCode,
-				body,
-			),
-		)
-		panic(err.Error())
-	}
-	if err != nil {
-		log_error("unable to receive a response from GitHub")
-		panic(err.Error())
-	}
-
-	err = json.Unmarshal(body, &commits)
-	if err != nil {
-		log_error("unable to unmarshall json")
-		panic(err.Error())
-	}
-
-	if len(commits) > 0 {
-		return commits[0].Sha
-	}
-	return ""
+// voprCandidate is a commit eligible to be assigned to a VOPR, together with
+// the PR it was pulled from (0 for main, which has no associated PR).
+type voprCandidate struct {
+	Commit   string
+	PRNumber int
 }
 
-func get_commit_hashes() []string {
-	// This is the GitHub API default.
+func get_vopr_candidates() ([]voprCandidate, error) {
+	// This is the GitHub API default; other forges are asked for the same
+	// page size for consistency.
 	const num_posts int = 30
-	var pull_requests []Issue
-	var vopr_commits []string
+	var pull_requests []PullRequest
+	var candidates []voprCandidate
 
 	// TODO should I add a high range check like i < 10? That's 300 PRs
 	// num_voprs - 1 because first VOPR always runs on main's latest commit
-	for i := 1; len(vopr_commits) < num_voprs-1; i++ {
+	for i := 1; len(candidates) < num_voprs-1; i++ {
 		// Pull requests will be ordered newest to oldest by default.
-		pull_requests = get_pull_requests(num_posts, i)
+		var err error
+		var has_more bool
+		pull_requests, has_more, err = source.ListPullRequests("vopr", i, num_posts)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list pull requests: %w", err)
+		}
 
 		for _, element := range pull_requests {
-			for _, label := range element.Labels {
-				if label.Name == "vopr" {
-					// Branches are returned in the format owner:branch_name.
-					_, branch_name, found := strings.Cut(element.Head.Label, ":")
-					if found && branch_name != "" {
-						commit := get_commits(branch_name)
-						if commit != "" {
-							// TODO regex check on commit at time of use in checkout step
-							vopr_commits = append(vopr_commits, commit)
-						}
-					}
-					break
-				}
+			commit, err := source.LatestCommit(element.BranchName)
+			if err != nil {
+				return nil, fmt.Errorf("unable to fetch latest commit: %w", err)
+			}
+			if commit != "" {
+				// TODO regex check on commit at time of use in checkout step
+				candidates = append(candidates, voprCandidate{Commit: commit, PRNumber: element.Number})
 			}
 
-			if len(vopr_commits) == num_voprs-1 {
+			if len(candidates) == num_voprs-1 {
 				break
 			}
 		}
-		// Exit the loop if there are no more pages of pull requests to be fetched from GitHub.
-		if len(pull_requests) < num_posts {
+		// Exit the loop once the forge reports no more pages. This must not be
+		// inferred from len(pull_requests), since that slice has already been
+		// filtered down to the `vopr` label and a full, mostly-unlabelled page
+		// would otherwise look like the last page.
+		if !has_more {
 			break
 		}
 	}
 
-	return vopr_commits
+	return candidates, nil
 }
 
-func get_vopr_assignments(vopr_commits []string) []string {
-	var num_pull_requests = len(vopr_commits)
-	var vopr_assignments []string
-
-	if num_pull_requests > 0 {
-		// The first VOPR always runs main
-		commit := get_commits("main")
-		if commit != "" {
-			vopr_assignments = append(vopr_assignments, commit)
-		}
+func get_commit_hashes() ([]string, error) {
+	candidates, err := get_vopr_candidates()
+	if err != nil {
+		return nil, err
+	}
+	vopr_commits := make([]string, len(candidates))
+	for i, candidate := range candidates {
+		vopr_commits[i] = candidate.Commit
+	}
+	return vopr_commits, nil
+}
 
-		// This calculates how many times each PR branch will be assigned to a VOPR.
-		var repeats = int((num_voprs - 1) / num_pull_requests)
-		// This calculates how many branches will have an additional assignment.
-		var remainders = (num_voprs - 1) % num_pull_requests
-		i := 1
-		commit_index := 0
-		for i < num_voprs {
-			for j := 0; j < repeats; j++ {
-				vopr_assignments = append(
-					vopr_assignments,
-					fmt.Sprintf("%s", vopr_commits[commit_index]),
-				)
-				i++
-			}
-			if remainders > 0 {
-				vopr_assignments = append(
-					vopr_assignments,
-					fmt.Sprintf("%s", vopr_commits[commit_index]),
-				)
-				remainders--
-				i++
-			}
-			commit_index++
-		}
-	} else {
-		commit := get_commits("main")
-		if commit != "" {
-			i := 0
-			for i < num_voprs {
-				vopr_assignments = append(vopr_assignments, commit)
-				i++
-			}
-		}
+// get_vopr_assignments assigns each VOPR a commit to run via rendezvous
+// hashing (see assignVoprsRendezvous), so that adding or removing one PR
+// only reshuffles the VOPRs whose assignment actually needs to change,
+// instead of every VOPR shifting to a different commit.
+func get_vopr_assignments(vopr_commits []string) ([]string, error) {
+	main_commit, err := source.LatestCommit("main")
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch latest commit: %w", err)
 	}
-	return vopr_assignments
-	// TODO: figure out what to do if you get null strings, just use word main?
+
+	return assignVoprsRendezvous(num_voprs, main_commit, vopr_commits), nil
 }
 
 func log_error(message string) {
@@ -331,25 +242,75 @@ func log_message(log_level string, message string) {
 }
 
 func main() {
+	// `vopr-hub login` bootstraps a GitHub token via the OAuth device flow;
+	// it doesn't need TIGERBEETLE_DIRECTORY/NUM_VOPRS/etc, so it's handled
+	// before the usual flag parsing and environment setup.
+	if len(os.Args) > 1 && os.Args[1] == "login" {
+		if err := runLogin(); err != nil {
+			log_error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Determine the mode in which to run the VOPR Hub
 	flag.BoolVar(&debug_mode, "debug", false, "enable debug logging")
+	serve_mode := flag.Bool("serve", false, "run as a long-running daemon instead of a one-shot assignment")
 	flag.Parse()
 
 	set_environment_variables()
 
+	// Cancel the root context on SIGINT/SIGTERM so a wedged git command
+	// gets killed and the hub exits cleanly instead of hanging.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	source, err = newSourceProvider(forge_type, repository_url, github_token)
+	if err != nil {
+		log_error(err.Error())
+		os.Exit(1)
+	}
+
+	worktrees = newWorktreeManager(bare_repository_directory, tigerbeetle_directory)
+	if err := worktrees.Prune(ctx); err != nil {
+		// Stale worktree state shouldn't stop the hub from running; the
+		// next FetchAll/Checkout will surface a real problem loudly.
+		log_error(err.Error())
+	}
+	if err := worktrees.FetchAll(ctx); err != nil {
+		log_error(err.Error())
+		os.Exit(1)
+	}
+
+	if *serve_mode {
+		poll_interval, database_path, listen_address := serve_environment_variables()
+		if err := serve(ctx, poll_interval, database_path, listen_address); err != nil {
+			log_error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Gets commit hashes for main and up to (NUM_VOPRS -1) PR branches that have the `vopr` label
-	vopr_commits := get_commit_hashes()
+	vopr_commits, err := get_commit_hashes()
+	if err != nil {
+		log_error(err.Error())
+		os.Exit(1)
+	}
 
 	// Assigns one commit for each VOPR to run on
-	vopr_assignments := get_vopr_assignments(vopr_commits)
-	// TODO remove - debugging
-	fmt.Println(vopr_assignments)
+	vopr_assignments, err := get_vopr_assignments(vopr_commits)
+	if err != nil {
+		log_error(err.Error())
+		os.Exit(1)
+	}
+	log_debug(fmt.Sprintf("vopr assignments: %v", vopr_assignments))
 
 	// TODO: index directories from 0
 	if current_vopr <= len(vopr_assignments) && current_vopr >= 1 {
-		checkout_branch(
-			vopr_assignments[current_vopr-1],
-			fmt.Sprintf("%s%d", tigerbeetle_directory, current_vopr),
-		)
+		if _, err := worktrees.Checkout(ctx, current_vopr, vopr_assignments[current_vopr-1]); err != nil {
+			log_error(err.Error())
+		}
 	}
 }
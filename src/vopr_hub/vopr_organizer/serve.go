@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// voprAssignmentInfo is the JSON shape served at /assignments.
+type voprAssignmentInfo struct {
+	Commit     string    `json:"commit"`
+	PRNumber   int       `json:"pr_number"`
+	AssignedAt time.Time `json:"assigned_at"`
+}
+
+// voprRunReport is what a VOPR runner POSTs to /report once a run finishes.
+type voprRunReport struct {
+	VoprID     int    `json:"vopr_id"`
+	Commit     string `json:"commit"`
+	Seed       string `json:"seed"`
+	Status     string `json:"status"`
+	StderrTail string `json:"stderr_tail"`
+	DurationMs int64  `json:"duration"`
+}
+
+// hubMetrics are the Prometheus counters/gauges exposed at /metrics.
+type hubMetrics struct {
+	polls                atomic.Int64
+	api_errors           atomic.Int64
+	assignment_churn     atomic.Int64
+	rate_limit_remaining atomic.Int64
+}
+
+// hub is the long-running daemon's state: which commit each VOPR is
+// currently assigned, whether it is busy (a run is outstanding), and a
+// handle to the SQLite database that records report history.
+type hub struct {
+	mu          sync.Mutex
+	assignments map[int]*voprAssignmentInfo
+	busy        map[int]bool
+	db          *sql.DB
+	metrics     hubMetrics
+}
+
+func newHub(database_path string) (*hub, error) {
+	db, err := sql.Open("sqlite", database_path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open sqlite database: %w", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS reports (
+			vopr_id     INTEGER NOT NULL,
+			commit_sha  TEXT NOT NULL,
+			seed        TEXT NOT NULL,
+			status      TEXT NOT NULL,
+			stderr_tail TEXT NOT NULL,
+			duration_ms INTEGER NOT NULL,
+			reported_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to create reports table: %w", err)
+	}
+
+	return &hub{
+		assignments: make(map[int]*voprAssignmentInfo),
+		busy:        make(map[int]bool),
+		db:          db,
+	}, nil
+}
+
+// pollOnce re-fetches commits for main and open `vopr`-labelled PRs, and
+// reassigns only VOPRs that are idle and whose target commit has changed;
+// VOPRs with a run outstanding keep their current assignment until they
+// report in, so a newly-landed PR doesn't throw away unrelated work.
+func (h *hub) pollOnce(ctx context.Context) {
+	h.metrics.polls.Add(1)
+
+	// Newly-landed commits only exist in the bare repository's object
+	// database after a fetch; without this, Checkout below fails for every
+	// commit pushed since the hub started, and re-assignment silently does
+	// nothing.
+	if err := worktrees.FetchAll(ctx); err != nil {
+		log_error("unable to fetch: " + err.Error())
+		h.metrics.api_errors.Add(1)
+		return
+	}
+
+	candidates, err := get_vopr_candidates()
+	if err != nil {
+		log_error("unable to list vopr candidates: " + err.Error())
+		h.metrics.api_errors.Add(1)
+		return
+	}
+	vopr_commits := make([]string, len(candidates))
+	pr_number_by_commit := make(map[string]int, len(candidates))
+	for i, candidate := range candidates {
+		vopr_commits[i] = candidate.Commit
+		pr_number_by_commit[candidate.Commit] = candidate.PRNumber
+	}
+
+	vopr_assignments, err := get_vopr_assignments(vopr_commits)
+	if err != nil {
+		log_error("unable to compute vopr assignments: " + err.Error())
+		h.metrics.api_errors.Add(1)
+		return
+	}
+
+	h.metrics.rate_limit_remaining.Store(github_rate_limit_remaining.Load())
+
+	// Decide which VOPRs need reassigning while holding the lock only long
+	// enough to read state, then run the (potentially slow) checkouts with
+	// the lock released so /assignments, /report and /metrics don't block
+	// on git for the duration of the poll.
+	type reassignment struct {
+		vopr_id int
+		commit  string
+	}
+	h.mu.Lock()
+	var pending []reassignment
+	for i, commit := range vopr_assignments {
+		vopr_id := i + 1
+		if h.busy[vopr_id] {
+			continue
+		}
+		if existing, ok := h.assignments[vopr_id]; ok && existing.Commit == commit {
+			continue
+		}
+		pending = append(pending, reassignment{vopr_id: vopr_id, commit: commit})
+	}
+	h.mu.Unlock()
+
+	for _, r := range pending {
+		if _, err := worktrees.Checkout(ctx, r.vopr_id, r.commit); err != nil {
+			log_error(fmt.Sprintf("vopr %d: %s", r.vopr_id, err.Error()))
+			h.metrics.api_errors.Add(1)
+			continue
+		}
+
+		h.mu.Lock()
+		h.assignments[r.vopr_id] = &voprAssignmentInfo{
+			Commit:     r.commit,
+			PRNumber:   pr_number_by_commit[r.commit],
+			AssignedAt: time.Now(),
+		}
+		h.busy[r.vopr_id] = true
+		h.metrics.assignment_churn.Add(1)
+		h.mu.Unlock()
+	}
+}
+
+// pollLoop runs pollOnce every interval until ctx is cancelled.
+func (h *hub) pollLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	h.pollOnce(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.pollOnce(ctx)
+		}
+	}
+}
+
+func (h *hub) handleAssignments(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	snapshot := make(map[string]voprAssignmentInfo, len(h.assignments))
+	for vopr_id, assignment := range h.assignments {
+		snapshot[fmt.Sprintf("%d", vopr_id)] = *assignment
+	}
+	h.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+		log_error("failed to encode assignments: " + err.Error())
+	}
+}
+
+func (h *hub) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "ok")
+}
+
+func (h *hub) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP vopr_hub_polls_total Number of times the hub has polled the forge for PRs.\n")
+	fmt.Fprintf(w, "# TYPE vopr_hub_polls_total counter\n")
+	fmt.Fprintf(w, "vopr_hub_polls_total %d\n", h.metrics.polls.Load())
+	fmt.Fprintf(w, "# HELP vopr_hub_api_errors_total Number of forge/worktree errors encountered while polling or commenting on pull requests.\n")
+	fmt.Fprintf(w, "# TYPE vopr_hub_api_errors_total counter\n")
+	fmt.Fprintf(w, "vopr_hub_api_errors_total %d\n", h.metrics.api_errors.Load())
+	fmt.Fprintf(w, "# HELP vopr_hub_assignment_churn_total Number of VOPR re-assignments made.\n")
+	fmt.Fprintf(w, "# TYPE vopr_hub_assignment_churn_total counter\n")
+	fmt.Fprintf(w, "vopr_hub_assignment_churn_total %d\n", h.metrics.assignment_churn.Load())
+	fmt.Fprintf(w, "# HELP vopr_hub_rate_limit_remaining Last observed X-RateLimit-Remaining from the forge API.\n")
+	fmt.Fprintf(w, "# TYPE vopr_hub_rate_limit_remaining gauge\n")
+	fmt.Fprintf(w, "vopr_hub_rate_limit_remaining %d\n", h.metrics.rate_limit_remaining.Load())
+}
+
+func (h *hub) handleReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var report voprRunReport
+	if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+		http.Error(w, "invalid report body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err := h.db.Exec(
+		`INSERT INTO reports (vopr_id, commit_sha, seed, status, stderr_tail, duration_ms, reported_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		report.VoprID, report.Commit, report.Seed, report.Status, report.StderrTail, report.DurationMs, time.Now(),
+	)
+	if err != nil {
+		log_error("failed to persist report: " + err.Error())
+		http.Error(w, "failed to persist report", http.StatusInternalServerError)
+		return
+	}
+
+	h.mu.Lock()
+	pr_number := 0
+	if assignment, ok := h.assignments[report.VoprID]; ok {
+		pr_number = assignment.PRNumber
+	}
+	h.busy[report.VoprID] = false
+	h.mu.Unlock()
+
+	if pr_number != 0 {
+		comment := fmt.Sprintf(
+			"VOPR %d ran seed `%s` on `%s`: **%s** (took %dms)\n\n```\n%s\n```",
+			report.VoprID, report.Seed, report.Commit, report.Status, report.DurationMs, report.StderrTail,
+		)
+		if err := source.CommentOnPullRequest(pr_number, comment); err != nil {
+			log_error("failed to comment on pull request: " + err.Error())
+			h.metrics.api_errors.Add(1)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serve runs the hub as a long-running daemon: it keeps polling the forge
+// for newly-landed `vopr` PRs and exposes an HTTP API for assignments,
+// health, metrics, and VOPR run reports.
+func serve(ctx context.Context, poll_interval time.Duration, database_path, listen_address string) error {
+	h, err := newHub(database_path)
+	if err != nil {
+		return err
+	}
+	defer h.db.Close()
+
+	go h.pollLoop(ctx, poll_interval)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/assignments", h.handleAssignments)
+	mux.HandleFunc("/healthz", h.handleHealthz)
+	mux.HandleFunc("/metrics", h.handleMetrics)
+	mux.HandleFunc("/report", h.handleReport)
+
+	server := &http.Server{Addr: listen_address, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdown_ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown_ctx)
+	}()
+
+	log_info("vopr hub serving on " + listen_address)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("http server failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// github_rate_limit_remaining is the last X-RateLimit-Remaining observed on
+// a GitHub API response, surfaced by the hub daemon as the
+// vopr_hub_rate_limit_remaining gauge.
+var github_rate_limit_remaining atomic.Int64
+
+// PullRequest is the forge-agnostic view of a pull/merge request that the
+// hub cares about: which branch it points at. Everything forge-specific
+// (label shapes, head.label vs source_branch, owner:branch parsing) is
+// resolved inside the SourceProvider implementation.
+type PullRequest struct {
+	Number     int
+	BranchName string
+}
+
+// SourceProvider abstracts the forge (GitHub, Gitea, GitLab, Gogs) that
+// hosts the TigerBeetle mirror a given VOPR hub instance watches. Branch
+// label formatting differs between forges, so providers are responsible
+// for parsing their own head-branch representation and for filtering by
+// label before returning pull requests.
+type SourceProvider interface {
+	// ListPullRequests returns open pull/merge requests carrying the given
+	// label, newest first, paginated like the underlying forge API.
+	// has_more reports whether the forge returned a full page (i.e. there
+	// may be more pages), independent of how many of those pull requests
+	// survived the `label` filter — pagination must not stop early just
+	// because a filtered page looks short.
+	ListPullRequests(label string, page, perPage int) (pull_requests []PullRequest, has_more bool, err error)
+	// LatestCommit returns the HEAD sha of branch, or "" if the branch has
+	// no commits (e.g. it no longer exists).
+	LatestCommit(branch string) (string, error)
+	// CommentOnPullRequest posts body as a new comment on pull/merge request
+	// number, e.g. a pass/fail summary once a VOPR run completes.
+	CommentOnPullRequest(number int, body string) error
+}
+
+// newSourceProvider builds the SourceProvider for forgeType, pointed at
+// apiBaseURL (e.g. "https://api.github.com/repos/tigerbeetle/tigerbeetle"
+// or the equivalent path on a self-hosted Gitea/GitLab/Gogs instance).
+// githubToken is only used by the github provider; it is sent as a Bearer
+// token on every request and lifts the hub above the 60 req/hour/IP
+// unauthenticated rate limit.
+func newSourceProvider(forgeType, apiBaseURL, githubToken string) (SourceProvider, error) {
+	switch forgeType {
+	case "", "github":
+		return &githubProvider{baseURL: apiBaseURL, token: githubToken}, nil
+	case "gitea":
+		return &giteaProvider{baseURL: apiBaseURL}, nil
+	case "gitlab":
+		return &gitlabProvider{baseURL: apiBaseURL}, nil
+	case "gogs":
+		return &gogsProvider{baseURL: apiBaseURL}, nil
+	default:
+		return nil, fmt.Errorf("unknown FORGE_TYPE %q (expected github, gitea, gitlab or gogs)", forgeType)
+	}
+}
+
+func httpGetJSON(url string, out any) error {
+	res, err := http.Get(url)
+	if err != nil {
+		return fmt.Errorf("unable to create get request: %w", err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("unable to receive a response: %w", err)
+	}
+	if res.StatusCode > 299 {
+		return fmt.Errorf("response failed with status code: %d and\nbody: %s\n", res.StatusCode, body)
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("unable to unmarshal json: %w", err)
+	}
+	return nil
+}
+
+// httpPostJSON POSTs payload as JSON to url, optionally with a bearer token,
+// and discards a successful response body (the hub only needs to know
+// whether the comment landed).
+func httpPostJSON(url, token string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("unable to marshal json: %w", err)
+	}
+
+	request, err := http.NewRequest(http.MethodPost, url, strings.NewReader(string(body)))
+	if err != nil {
+		return fmt.Errorf("unable to create post request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("unable to post: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode > 299 {
+		response_body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("response failed with status code: %d and\nbody: %s\n", res.StatusCode, response_body)
+	}
+	return nil
+}
+
+// --- GitHub ---
+
+type githubProvider struct {
+	baseURL string
+	token   string
+}
+
+const github_max_retries = 5
+
+// getJSON is like httpGetJSON but authenticates with p.token (when set) and
+// retries on rate limiting, backing off exponentially unless the response
+// names an exact `Retry-After`.
+func (p *githubProvider) getJSON(requestURL string, out any) error {
+	backoff := time.Second
+
+	for attempt := 0; ; attempt++ {
+		request, err := http.NewRequest(http.MethodGet, requestURL, nil)
+		if err != nil {
+			return fmt.Errorf("unable to create get request: %w", err)
+		}
+		if p.token != "" {
+			request.Header.Set("Authorization", "Bearer "+p.token)
+		}
+
+		res, err := http.DefaultClient.Do(request)
+		if err != nil {
+			return fmt.Errorf("unable to create get request: %w", err)
+		}
+
+		if remaining := res.Header.Get("X-RateLimit-Remaining"); remaining != "" {
+			if value, err := strconv.Atoi(remaining); err == nil {
+				github_rate_limit_remaining.Store(int64(value))
+			}
+		}
+
+		if wait, rate_limited := githubRateLimitWait(res, backoff); rate_limited {
+			res.Body.Close()
+			if attempt >= github_max_retries {
+				return fmt.Errorf("exceeded retries against github api due to rate limiting")
+			}
+			log_debug(fmt.Sprintf("github rate limited, retrying in %s", wait))
+			time.Sleep(wait)
+			backoff *= 2
+			continue
+		}
+
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return fmt.Errorf("unable to receive a response: %w", err)
+		}
+		if res.StatusCode > 299 {
+			return fmt.Errorf("response failed with status code: %d and\nbody: %s\n", res.StatusCode, body)
+		}
+
+		if err := json.Unmarshal(body, out); err != nil {
+			return fmt.Errorf("unable to unmarshal json: %w", err)
+		}
+		return nil
+	}
+}
+
+// githubRateLimitWait reports how long to back off before retrying res, and
+// whether res was actually rate-limited. Retry-After is honored verbatim
+// when GitHub sends it; otherwise the caller's exponential backoff is used.
+func githubRateLimitWait(res *http.Response, backoff time.Duration) (time.Duration, bool) {
+	if res.StatusCode != http.StatusForbidden && res.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	if remaining := res.Header.Get("X-RateLimit-Remaining"); remaining != "" && remaining != "0" {
+		return 0, false
+	}
+	if retry_after := res.Header.Get("Retry-After"); retry_after != "" {
+		if seconds, err := strconv.Atoi(retry_after); err == nil {
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	return backoff, true
+}
+
+type githubLabel struct {
+	Name string `json:"name"`
+}
+
+type githubHead struct {
+	Label string `json:"label"`
+}
+
+type githubIssue struct {
+	Number int           `json:"number"`
+	Labels []githubLabel `json:"labels"`
+	Head   githubHead    `json:"head"`
+}
+
+type githubCommit struct {
+	Sha string `json:"sha"`
+}
+
+func (p *githubProvider) ListPullRequests(label string, page, perPage int) ([]PullRequest, bool, error) {
+	var issues []githubIssue
+	url := fmt.Sprintf("%s/pulls?per_page=%d&page=%d", p.baseURL, perPage, page)
+	if err := p.getJSON(url, &issues); err != nil {
+		return nil, false, err
+	}
+
+	var pull_requests []PullRequest
+	for _, issue := range issues {
+		if !hasGithubLabel(issue.Labels, label) {
+			continue
+		}
+		// Branches are returned in the format owner:branch_name.
+		_, branch_name, found := strings.Cut(issue.Head.Label, ":")
+		if found && branch_name != "" {
+			pull_requests = append(pull_requests, PullRequest{Number: issue.Number, BranchName: branch_name})
+		}
+	}
+	// has_more is computed from the raw page length, before the `vopr` label
+	// filter is applied above: a page can be full of unlabelled PRs and still
+	// have more pages behind it.
+	return pull_requests, len(issues) == perPage, nil
+}
+
+func hasGithubLabel(labels []githubLabel, name string) bool {
+	for _, label := range labels {
+		if label.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *githubProvider) LatestCommit(branch string) (string, error) {
+	var commits []githubCommit
+	url := fmt.Sprintf("%s/commits?per_page=1&sha=%s", p.baseURL, branch)
+	if err := p.getJSON(url, &commits); err != nil {
+		return "", err
+	}
+	if len(commits) > 0 {
+		return commits[0].Sha, nil
+	}
+	return "", nil
+}
+
+func (p *githubProvider) CommentOnPullRequest(number int, body string) error {
+	url := fmt.Sprintf("%s/issues/%d/comments", p.baseURL, number)
+	return httpPostJSON(url, p.token, struct {
+		Body string `json:"body"`
+	}{Body: body})
+}
+
+// --- Gitea ---
+
+// Gitea mirrors GitHub's PR shape closely (head.label as "owner:branch",
+// labels as name objects), and supports filtering pulls by label server-side.
+type giteaProvider struct {
+	baseURL string
+}
+
+type giteaIssue struct {
+	Number int           `json:"number"`
+	Labels []githubLabel `json:"labels"`
+	Head   githubHead    `json:"head"`
+}
+
+type giteaCommit struct {
+	Sha string `json:"sha"`
+}
+
+func (p *giteaProvider) ListPullRequests(label string, page, perPage int) ([]PullRequest, bool, error) {
+	var issues []giteaIssue
+	url := fmt.Sprintf("%s/pulls?state=open&labels=%s&limit=%d&page=%d", p.baseURL, label, perPage, page)
+	if err := httpGetJSON(url, &issues); err != nil {
+		return nil, false, err
+	}
+
+	var pull_requests []PullRequest
+	for _, issue := range issues {
+		_, branch_name, found := strings.Cut(issue.Head.Label, ":")
+		if found && branch_name != "" {
+			pull_requests = append(pull_requests, PullRequest{Number: issue.Number, BranchName: branch_name})
+		}
+	}
+	// Gitea filters by label server-side, so the raw page length is already
+	// an accurate has-more signal.
+	return pull_requests, len(issues) == perPage, nil
+}
+
+func (p *giteaProvider) LatestCommit(branch string) (string, error) {
+	var commits []giteaCommit
+	url := fmt.Sprintf("%s/commits?sha=%s&limit=1", p.baseURL, branch)
+	if err := httpGetJSON(url, &commits); err != nil {
+		return "", err
+	}
+	if len(commits) > 0 {
+		return commits[0].Sha, nil
+	}
+	return "", nil
+}
+
+func (p *giteaProvider) CommentOnPullRequest(number int, body string) error {
+	url := fmt.Sprintf("%s/issues/%d/comments", p.baseURL, number)
+	return httpPostJSON(url, "", struct {
+		Body string `json:"body"`
+	}{Body: body})
+}
+
+// --- GitLab ---
+
+// GitLab's merge request API has no notion of a "head label"; the source
+// branch is a plain field, and labels are a flat list of strings that can
+// be filtered server-side.
+type gitlabProvider struct {
+	baseURL string
+}
+
+type gitlabMergeRequest struct {
+	IID          int      `json:"iid"`
+	SourceBranch string   `json:"source_branch"`
+	Labels       []string `json:"labels"`
+}
+
+type gitlabCommit struct {
+	ID string `json:"id"`
+}
+
+func (p *gitlabProvider) ListPullRequests(label string, page, perPage int) ([]PullRequest, bool, error) {
+	var merge_requests []gitlabMergeRequest
+	url := fmt.Sprintf("%s/merge_requests?state=opened&labels=%s&per_page=%d&page=%d", p.baseURL, label, perPage, page)
+	if err := httpGetJSON(url, &merge_requests); err != nil {
+		return nil, false, err
+	}
+
+	var pull_requests []PullRequest
+	for _, mr := range merge_requests {
+		if mr.SourceBranch != "" {
+			pull_requests = append(pull_requests, PullRequest{Number: mr.IID, BranchName: mr.SourceBranch})
+		}
+	}
+	// GitLab filters by label server-side, so the raw page length is already
+	// an accurate has-more signal.
+	return pull_requests, len(merge_requests) == perPage, nil
+}
+
+func (p *gitlabProvider) LatestCommit(branch string) (string, error) {
+	var commits []gitlabCommit
+	url := fmt.Sprintf("%s/repository/commits?ref_name=%s&per_page=1", p.baseURL, branch)
+	if err := httpGetJSON(url, &commits); err != nil {
+		return "", err
+	}
+	if len(commits) > 0 {
+		return commits[0].ID, nil
+	}
+	return "", nil
+}
+
+func (p *gitlabProvider) CommentOnPullRequest(number int, body string) error {
+	url := fmt.Sprintf("%s/merge_requests/%d/notes", p.baseURL, number)
+	return httpPostJSON(url, "", struct {
+		Body string `json:"body"`
+	}{Body: body})
+}
+
+// --- Gogs ---
+
+// Gogs predates Gitea's label-filter query support, so pull requests are
+// filtered by label client-side, same as the original GitHub-only code did.
+type gogsProvider struct {
+	baseURL string
+}
+
+type gogsIssue struct {
+	Number int           `json:"number"`
+	Labels []githubLabel `json:"labels"`
+	Head   githubHead    `json:"head"`
+}
+
+type gogsCommit struct {
+	Sha string `json:"sha"`
+}
+
+func (p *gogsProvider) ListPullRequests(label string, page, perPage int) ([]PullRequest, bool, error) {
+	var issues []gogsIssue
+	url := fmt.Sprintf("%s/pulls?page=%d&limit=%d", p.baseURL, page, perPage)
+	if err := httpGetJSON(url, &issues); err != nil {
+		return nil, false, err
+	}
+
+	var pull_requests []PullRequest
+	for _, issue := range issues {
+		if !hasGithubLabel(issue.Labels, label) {
+			continue
+		}
+		_, branch_name, found := strings.Cut(issue.Head.Label, ":")
+		if found && branch_name != "" {
+			pull_requests = append(pull_requests, PullRequest{Number: issue.Number, BranchName: branch_name})
+		}
+	}
+	// Gogs has no server-side label filter, so pull requests are filtered
+	// client-side above; has_more is still derived from the raw page length.
+	return pull_requests, len(issues) == perPage, nil
+}
+
+func (p *gogsProvider) LatestCommit(branch string) (string, error) {
+	// Unlike the other forges' list endpoints, gogs' /commits/:ref returns a
+	// single commit object, not an array.
+	var commit gogsCommit
+	url := fmt.Sprintf("%s/commits/%s", p.baseURL, branch)
+	if err := httpGetJSON(url, &commit); err != nil {
+		return "", err
+	}
+	return commit.Sha, nil
+}
+
+func (p *gogsProvider) CommentOnPullRequest(number int, body string) error {
+	url := fmt.Sprintf("%s/issues/%d/comments", p.baseURL, number)
+	return httpPostJSON(url, "", struct {
+		Body string `json:"body"`
+	}{Body: body})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// worktreeManager maintains a single bare/mirror clone of the TigerBeetle
+// repository and hands each VOPR a cheap `git worktree add` pointing at its
+// assigned commit, instead of every VOPR keeping an independent clone. A
+// worktree checks out a detached SHA directly, so there is no branch-name
+// matching involved in "did the checkout succeed".
+type worktreeManager struct {
+	bare_repository_directory string
+	worktree_directory_prefix string
+}
+
+func newWorktreeManager(bare_repository_directory string, worktree_directory_prefix string) *worktreeManager {
+	return &worktreeManager{
+		bare_repository_directory: bare_repository_directory,
+		worktree_directory_prefix: worktree_directory_prefix,
+	}
+}
+
+// Prune clears out worktree administrative state left behind by worktree
+// directories that were deleted (or never cleaned up) by an unclean
+// shutdown. Safe to call on every startup.
+func (m *worktreeManager) Prune(ctx context.Context) error {
+	_, err := runGit(RunContext{
+		Context: ctx,
+		Dir:     m.bare_repository_directory,
+		Timeout: git_checkout_timeout,
+	}, "worktree", "prune")
+	return err
+}
+
+// FetchAll runs a single `git fetch --all` against the bare repo, so every
+// VOPR's target commit becomes available without each worktree re-fetching
+// the same refs.
+func (m *worktreeManager) FetchAll(ctx context.Context) error {
+	_, err := runGit(RunContext{
+		Context: ctx,
+		Dir:     m.bare_repository_directory,
+		Timeout: git_fetch_timeout,
+	}, "fetch", "--all")
+	return err
+}
+
+// Checkout ensures vopr_id's worktree exists and has commit checked out,
+// creating or recreating it as needed, and returns the worktree's directory.
+func (m *worktreeManager) Checkout(ctx context.Context, vopr_id int, commit string) (string, error) {
+	if !m.commitFetched(ctx, commit) {
+		return "", fmt.Errorf("commit %s has not been fetched into the bare repository", commit)
+	}
+
+	directory := fmt.Sprintf("%s%d", m.worktree_directory_prefix, vopr_id)
+
+	current_commit, exists := m.currentCommit(ctx, directory)
+	if exists {
+		if current_commit == commit {
+			// Already checked out at the right commit; nothing to do.
+			return directory, nil
+		}
+		if err := m.removeWorktree(ctx, directory); err != nil {
+			return "", err
+		}
+	}
+
+	_, err := runGit(RunContext{
+		Context: ctx,
+		Dir:     m.bare_repository_directory,
+		Timeout: git_checkout_timeout,
+	}, "worktree", "add", "--detach", directory, commit)
+	if err != nil {
+		return "", fmt.Errorf("failed to add worktree %s at %s: %w", directory, commit, err)
+	}
+
+	return directory, nil
+}
+
+// commitFetched reports whether commit is present in the bare repository's
+// object database, so a missing commit is reported as a clean error instead
+// of a confusing `git worktree add` failure.
+func (m *worktreeManager) commitFetched(ctx context.Context, commit string) bool {
+	_, err := runGit(RunContext{
+		Context: ctx,
+		Dir:     m.bare_repository_directory,
+		Timeout: git_checkout_timeout,
+	}, "cat-file", "-e", commit+"^{commit}")
+	return err == nil
+}
+
+// currentCommit returns the commit checked out in directory, and whether
+// directory is a worktree at all.
+func (m *worktreeManager) currentCommit(ctx context.Context, directory string) (string, bool) {
+	if _, err := os.Stat(directory); err != nil {
+		return "", false
+	}
+
+	output, err := runGit(RunContext{
+		Context: ctx,
+		Dir:     directory,
+		Timeout: git_checkout_timeout,
+	}, "rev-parse", "HEAD")
+	if err != nil {
+		return "", false
+	}
+
+	return strings.TrimRight(string(output), "\n"), true
+}
+
+func (m *worktreeManager) removeWorktree(ctx context.Context, directory string) error {
+	_, err := runGit(RunContext{
+		Context: ctx,
+		Dir:     m.bare_repository_directory,
+		Timeout: git_checkout_timeout,
+	}, "worktree", "remove", "--force", directory)
+	if err != nil {
+		return fmt.Errorf("failed to remove stale worktree %s: %w", directory, err)
+	}
+	return nil
+}